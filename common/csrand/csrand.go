@@ -0,0 +1,66 @@
+// Package csrand provides helpers (Bytes, Uint32, Intn, IntRange and an
+// io.Reader) backed by crypto/rand, so that the dispatcher's seed and key
+// generation code shares a single source of randomness. It has no Rand type
+// and does not implement math/rand's Source interface.
+package csrand
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// Bytes returns a byte slice of size n filled with data obtained via
+// crypto/rand. It will panic on failure as the CSPRNG being broken is not
+// a recoverable condition.
+func Bytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic("csrand: failed to read from CSPRNG: " + err.Error())
+	}
+	return b
+}
+
+// Uint32 returns a uniformly distributed uint32 obtained via crypto/rand.
+func Uint32() uint32 {
+	var b [4]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		panic("csrand: failed to read from CSPRNG: " + err.Error())
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// Intn returns a uniformly distributed int in the range [0, max) obtained
+// via crypto/rand. It will panic if max <= 0.
+func Intn(max int) int {
+	if max <= 0 {
+		panic("csrand: Intn called with max <= 0")
+	}
+
+	// Use rejection sampling to avoid modulo bias: keeping every draw of
+	// Uint32 would make v%n biased toward the low values whenever n does
+	// not evenly divide 2^32, so discard draws at or above the largest
+	// multiple of n that is at most 2^32 and resample. When n divides 2^32
+	// that multiple is 2^32 itself and no draw is discarded.
+	n := uint32(max)
+	limit := uint64(1)<<32 - (uint64(1)<<32)%uint64(n)
+	for {
+		v := Uint32()
+		if uint64(v) < limit {
+			return int(v % n)
+		}
+	}
+}
+
+// IntRange returns a uniformly distributed int in the range [min, max].
+func IntRange(min, max int) int {
+	if max < min {
+		panic("csrand: IntRange called with max < min")
+	}
+	return min + Intn(max-min+1)
+}
+
+// Reader is a io.Reader that reads from the CSPRNG, provided so call sites
+// that already expect an io.Reader (e.g. existing DRBG seed generation)
+// don't need to special case crypto/rand.Reader directly.
+var Reader io.Reader = rand.Reader
@@ -0,0 +1,42 @@
+package csrand
+
+import "testing"
+
+func TestIntnRange(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		v := Intn(7)
+		if v < 0 || v >= 7 {
+			t.Fatalf("Intn(7) returned out-of-range value: %d", v)
+		}
+	}
+}
+
+func TestIntnPowerOfTwo(t *testing.T) {
+	// max values that evenly divide 2^32 exercise the no-rejection path.
+	for _, max := range []int{1, 2, 4, 256, 65536} {
+		for i := 0; i < 100; i++ {
+			v := Intn(max)
+			if v < 0 || v >= max {
+				t.Fatalf("Intn(%d) returned out-of-range value: %d", max, v)
+			}
+		}
+	}
+}
+
+func TestIntnPanicsOnNonPositiveMax(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Intn(0) did not panic")
+		}
+	}()
+	Intn(0)
+}
+
+func TestIntRange(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		v := IntRange(10, 20)
+		if v < 10 || v > 20 {
+			t.Fatalf("IntRange(10, 20) returned out-of-range value: %d", v)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package drbg implements the hash-DRBG based length-distribution seed used
+// to keep the obfs4 client and server's packet padding in sync, and by any
+// other transport that wants deterministic-from-seed pseudo-random streams.
+package drbg
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/csrand"
+)
+
+const (
+	// SeedLength is the length of the DRBG seed in bytes.
+	SeedLength = 32
+
+	seedCounterSize = 4
+)
+
+// Seed is a DRBG seed that is exchanged between the client and server via
+// the obfs4 packet layer's packetTypePrngSeed control packet.
+type Seed [SeedLength]byte
+
+// Bytes returns a pointer to the raw seed bytes.
+func (seed *Seed) Bytes() *[SeedLength]byte {
+	return (*[SeedLength]byte)(seed)
+}
+
+// Hex returns the hexdecimal representation of the seed.
+func (seed Seed) Hex() string {
+	return fmt.Sprintf("%x", seed[:])
+}
+
+// NewSeed returns a new Seed, initialized with the output from the CSPRNG.
+func NewSeed() (*Seed, error) {
+	seed := new(Seed)
+	copy(seed[:], csrand.Bytes(SeedLength))
+	return seed, nil
+}
+
+// SeedFromBytes creates a Seed from the given byte slice, which must be
+// SeedLength bytes long.
+func SeedFromBytes(b []byte) (*Seed, error) {
+	if len(b) != SeedLength {
+		return nil, fmt.Errorf("drbg: invalid seed length: %d", len(b))
+	}
+	seed := new(Seed)
+	copy(seed[:], b)
+	return seed, nil
+}
+
+// SeedFromHex creates a Seed from the given hexdecimal string.
+func SeedFromHex(encoded string) (*Seed, error) {
+	raw, err := decodeHex(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("drbg: failed to decode seed: %s", err)
+	}
+	return SeedFromBytes(raw)
+}
+
+// HashDrbg is a CSPRNG based off HMAC-SHA256 in counter mode, keyed with a
+// Seed, used to derive the pseudo-random stream that drives the length
+// distribution.
+type HashDrbg struct {
+	seed    *Seed
+	sizeFn  func() int
+	mac     interface {
+		Write(p []byte) (int, error)
+		Sum(b []byte) []byte
+		Reset()
+	}
+	ctr uint32
+}
+
+// NewHashDrbg makes a HashDrbg instance based off an optional seed. If seed
+// is nil, a new Seed is generated.
+func NewHashDrbg(seed *Seed) (*HashDrbg, error) {
+	if seed == nil {
+		var err error
+		seed, err = NewSeed()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	drbg := new(HashDrbg)
+	drbg.seed = seed
+	drbg.mac = hmac.New(sha256.New, seed.Bytes()[:])
+	return drbg, nil
+}
+
+// NextBlock returns the next 32 byte PRNG block.
+func (drbg *HashDrbg) NextBlock() []byte {
+	var ctrBytes [seedCounterSize]byte
+	binary.BigEndian.PutUint32(ctrBytes[:], drbg.ctr)
+
+	drbg.mac.Reset()
+	drbg.mac.Write(ctrBytes[:])
+	block := drbg.mac.Sum(nil)
+	drbg.ctr++
+
+	return block
+}
+
+// Int63 returns a uniformly distributed pseudo-random int63 derived from the
+// DRBG's keystream, following the rand.Source interface so a HashDrbg can
+// back a math/rand.Rand.
+func (drbg *HashDrbg) Int63() int64 {
+	block := drbg.NextBlock()
+	return int64(binary.BigEndian.Uint64(block) & (1<<63 - 1))
+}
+
+// Seed is a no-op, HashDrbg instances are always seeded at construction.
+func (drbg *HashDrbg) Seed(int64) {}
+
+func decodeHex(s string) ([]byte, error) {
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("odd length hex string")
+	}
+	b := make([]byte, len(s)/2)
+	for i := range b {
+		hi, err := hexVal(s[i*2])
+		if err != nil {
+			return nil, err
+		}
+		lo, err := hexVal(s[i*2+1])
+		if err != nil {
+			return nil, err
+		}
+		b[i] = hi<<4 | lo
+	}
+	return b, nil
+}
+
+func hexVal(c byte) (byte, error) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', nil
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, nil
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, nil
+	default:
+		return 0, fmt.Errorf("invalid hex character: %c", c)
+	}
+}
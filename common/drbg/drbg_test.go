@@ -0,0 +1,63 @@
+package drbg
+
+import "testing"
+
+func TestHashDrbgDeterministic(t *testing.T) {
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatalf("NewSeed: %v", err)
+	}
+
+	a, err := NewHashDrbg(seed)
+	if err != nil {
+		t.Fatalf("NewHashDrbg: %v", err)
+	}
+	b, err := NewHashDrbg(seed)
+	if err != nil {
+		t.Fatalf("NewHashDrbg: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		blockA := a.NextBlock()
+		blockB := b.NextBlock()
+		if string(blockA) != string(blockB) {
+			t.Fatalf("block %d diverged between two DRBGs seeded identically", i)
+		}
+	}
+}
+
+func TestSeedFromBytesRoundTrip(t *testing.T) {
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatalf("NewSeed: %v", err)
+	}
+
+	roundTripped, err := SeedFromBytes(seed.Bytes()[:])
+	if err != nil {
+		t.Fatalf("SeedFromBytes: %v", err)
+	}
+	if roundTripped.Hex() != seed.Hex() {
+		t.Fatalf("round-tripped seed %s != original %s", roundTripped.Hex(), seed.Hex())
+	}
+}
+
+func TestSeedFromHexRoundTrip(t *testing.T) {
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatalf("NewSeed: %v", err)
+	}
+
+	roundTripped, err := SeedFromHex(seed.Hex())
+	if err != nil {
+		t.Fatalf("SeedFromHex: %v", err)
+	}
+	if roundTripped.Hex() != seed.Hex() {
+		t.Fatalf("round-tripped seed %s != original %s", roundTripped.Hex(), seed.Hex())
+	}
+}
+
+func TestSeedFromBytesRejectsWrongLength(t *testing.T) {
+	if _, err := SeedFromBytes(make([]byte, SeedLength-1)); err == nil {
+		t.Fatal("expected an error for a too-short seed")
+	}
+}
@@ -0,0 +1,229 @@
+// Package elligator2 implements the Elligator2 map for Curve25519, used to
+// generate keypairs whose public point can be encoded as a uniformly random
+// 32-byte string. obfs4's ntor handshake exchanges public keys this way so
+// they're indistinguishable from random on the wire; only about half of
+// random Curve25519 points have a valid representative, so key generation
+// must retry until it finds one.
+//
+// This is a local, dependency-free implementation (earlier revisions of
+// this package depended on github.com/agl/ed25519/extra25519, which no
+// longer contains usable code at the pinned version) built on top of
+// golang.org/x/crypto/curve25519 for the scalar multiplication and
+// math/big for the field arithmetic the map itself requires.
+package elligator2
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+var (
+	// p is the Curve25519 field prime, 2^255 - 19.
+	p = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	// half is (p-1)/2, used to fold a representative and its negation down
+	// to a single canonical encoding.
+	half = new(big.Int).Rsh(new(big.Int).Sub(p, big.NewInt(1)), 1)
+
+	// montA is the Montgomery coefficient A in Curve25519's y^2 = x^3 + A
+	// x^2 + x.
+	montA = big.NewInt(486662)
+
+	// nonResidue is a fixed quadratic non-residue mod p, required by the
+	// Elligator2 construction. 2 is a non-residue here because p = 5 mod 8.
+	nonResidue = big.NewInt(2)
+)
+
+// GenerateKeypair samples Curve25519 keypairs until it finds one whose
+// public key has a valid Elligator2 representative, since only about half
+// of random points qualify. It returns the clamped private scalar, the
+// public key (the point's u-coordinate), and the representative.
+func GenerateKeypair() (priv, pub, representative *[32]byte, err error) {
+	for {
+		var candidatePriv [32]byte
+		if _, err := io.ReadFull(rand.Reader, candidatePriv[:]); err != nil {
+			return nil, nil, nil, fmt.Errorf("elligator2: failed to read random scalar: %w", err)
+		}
+		clamp(&candidatePriv)
+
+		var candidatePub [32]byte
+		curve25519.ScalarBaseMult(&candidatePub, &candidatePriv)
+
+		rep, ok := representativeOf(&candidatePub)
+		if !ok {
+			continue
+		}
+
+		return &candidatePriv, &candidatePub, rep, nil
+	}
+}
+
+// clamp applies the standard X25519 private scalar clamping.
+func clamp(k *[32]byte) {
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+}
+
+// representativeOf returns the Elligator2 uniform representative for the
+// Curve25519 public key pub, and whether one exists. Given a candidate
+// representative r, the receiver recovers the same public key via
+// u = -A / (1 + 2r^2); inverting that relation for a known u = pub yields
+// r^2 = (-A-u) / (2u), which exists as a field element iff that quotient
+// is a quadratic residue mod p.
+func representativeOf(pub *[32]byte) (*[32]byte, bool) {
+	u := leBytesToBigInt(pub[:])
+	if u.Sign() == 0 {
+		return nil, false
+	}
+
+	denom := new(big.Int).Mul(nonResidue, u)
+	denom.Mod(denom, p)
+	if denom.Sign() == 0 {
+		return nil, false
+	}
+	denomInv := new(big.Int).ModInverse(denom, p)
+	if denomInv == nil {
+		return nil, false
+	}
+
+	num := new(big.Int).Add(montA, u)
+	num.Neg(num)
+	num.Mod(num, p)
+
+	rSquared := new(big.Int).Mul(num, denomInv)
+	rSquared.Mod(rSquared, p)
+
+	r, ok := modSqrt(rSquared)
+	if !ok {
+		return nil, false
+	}
+
+	// Fold r and p-r (both square to the same value) down to the one in
+	// [0, (p-1)/2], so encoding is deterministic.
+	if r.Cmp(half) == 1 {
+		r.Sub(p, r)
+	}
+
+	out := bigIntToLEBytes(r)
+	return &out, true
+}
+
+// PublicKeyFromRepresentative recovers the Curve25519 public key that
+// representative, as produced by GenerateKeypair, encodes.
+func PublicKeyFromRepresentative(representative *[32]byte) *[32]byte {
+	r := leBytesToBigInt(representative[:])
+
+	rSquared := new(big.Int).Mul(r, r)
+	rSquared.Mod(rSquared, p)
+
+	denom := new(big.Int).Mul(nonResidue, rSquared)
+	denom.Add(denom, big.NewInt(1))
+	denom.Mod(denom, p)
+
+	denomInv := new(big.Int).ModInverse(denom, p)
+	u := new(big.Int).Mul(montA, denomInv)
+	u.Neg(u)
+	u.Mod(u, p)
+
+	out := bigIntToLEBytes(u)
+	return &out
+}
+
+// isSquare reports whether a is a quadratic residue mod p, via Euler's
+// criterion. Zero counts as a square.
+func isSquare(a *big.Int) bool {
+	if a.Sign() == 0 {
+		return true
+	}
+	r := new(big.Int).Exp(a, half, p)
+	return r.Cmp(big.NewInt(1)) == 0
+}
+
+// modSqrt returns a square root of a mod p via Tonelli-Shanks, and whether a
+// is actually a square.
+func modSqrt(a *big.Int) (*big.Int, bool) {
+	if a.Sign() == 0 {
+		return big.NewInt(0), true
+	}
+	if !isSquare(a) {
+		return nil, false
+	}
+
+	// Factor p-1 = q * 2^s with q odd.
+	q := new(big.Int).Sub(p, big.NewInt(1))
+	s := 0
+	for q.Bit(0) == 0 {
+		q.Rsh(q, 1)
+		s++
+	}
+
+	// Find a quadratic non-residue z to seed the algorithm.
+	z := big.NewInt(2)
+	for isSquare(z) {
+		z.Add(z, big.NewInt(1))
+	}
+
+	m := s
+	c := new(big.Int).Exp(z, q, p)
+	t := new(big.Int).Exp(a, q, p)
+	qPlus1Over2 := new(big.Int).Rsh(new(big.Int).Add(q, big.NewInt(1)), 1)
+	r := new(big.Int).Exp(a, qPlus1Over2, p)
+
+	one := big.NewInt(1)
+	two := big.NewInt(2)
+	for {
+		if t.Cmp(one) == 0 {
+			// Self-check: verify the result before trusting it.
+			verify := new(big.Int).Exp(r, two, p)
+			if verify.Cmp(a) != 0 {
+				return nil, false
+			}
+			return r, true
+		}
+
+		i := 0
+		tt := new(big.Int).Set(t)
+		for tt.Cmp(one) != 0 {
+			tt.Exp(tt, two, p)
+			i++
+			if i == m {
+				return nil, false
+			}
+		}
+
+		b := new(big.Int).Exp(c, new(big.Int).Lsh(one, uint(m-i-1)), p)
+		m = i
+		c = new(big.Int).Exp(b, two, p)
+		t.Mul(t, c)
+		t.Mod(t, p)
+		r.Mul(r, b)
+		r.Mod(r, p)
+	}
+}
+
+// leBytesToBigInt interprets b as a little-endian field element, matching
+// the byte order golang.org/x/crypto/curve25519 uses for scalars and
+// u-coordinates.
+func leBytesToBigInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i, v := range b {
+		be[len(b)-1-i] = v
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// bigIntToLEBytes encodes x as a 32-byte little-endian field element.
+func bigIntToLEBytes(x *big.Int) [32]byte {
+	var out [32]byte
+	be := x.Bytes()
+	n := len(be)
+	for i := 0; i < n; i++ {
+		out[i] = be[n-1-i]
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package elligator2
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestGenerateKeypairRoundTrips(t *testing.T) {
+	for i := 0; i < 32; i++ {
+		priv, pub, rep, err := GenerateKeypair()
+		if err != nil {
+			t.Fatalf("GenerateKeypair: %v", err)
+		}
+
+		var want [32]byte
+		curve25519.ScalarBaseMult(&want, priv)
+		if want != *pub {
+			t.Fatalf("pub does not match scalar base mult of priv")
+		}
+
+		got := PublicKeyFromRepresentative(rep)
+		if *got != *pub {
+			t.Fatalf("PublicKeyFromRepresentative(rep) = %x, want %x", *got, *pub)
+		}
+	}
+}
+
+func TestGenerateKeypairVaries(t *testing.T) {
+	_, pub1, rep1, err := GenerateKeypair()
+	if err != nil {
+		t.Fatalf("GenerateKeypair: %v", err)
+	}
+	_, pub2, rep2, err := GenerateKeypair()
+	if err != nil {
+		t.Fatalf("GenerateKeypair: %v", err)
+	}
+
+	if *pub1 == *pub2 {
+		t.Fatal("two independent keypairs produced the same public key")
+	}
+	if *rep1 == *rep2 {
+		t.Fatal("two independent keypairs produced the same representative")
+	}
+}
@@ -0,0 +1,106 @@
+// Package probdist implements a weighted probability distribution suitable
+// for sampling padding/frame lengths from a DRBG-derived keystream, so both
+// ends of a connection agree on the same sequence of lengths once they
+// share a seed.
+package probdist
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/drbg"
+)
+
+// biasedDist is not used by WeightedDist; the biased weighting is set up
+// in reset.
+const biasedDist = 0.0
+
+// WeightedDist is a weighted distribution used to sample a value in
+// [minValue, maxValue]. When biased, the value at offset i from minValue
+// has weight 1/(i+1), so values towards the start of the range are more
+// likely; otherwise every value has the same weight.
+type WeightedDist struct {
+	sync.Mutex
+
+	weights  []float64
+	minValue int
+	maxValue int
+
+	rng  *rand.Rand
+	seed *drbg.Seed
+
+	biased bool
+}
+
+// New creates a new WeightedDist using the given seed, with values in the
+// range [minValue, maxValue], optionally biased towards smaller values.
+func New(seed *drbg.Seed, minValue, maxValue int, biased bool) *WeightedDist {
+	if minValue > maxValue {
+		minValue, maxValue = maxValue, minValue
+	}
+
+	w := new(WeightedDist)
+	w.minValue = minValue
+	w.maxValue = maxValue
+	w.biased = biased
+	w.reset(seed)
+
+	return w
+}
+
+// reset regenerates the distribution's weights and backing PRNG from seed,
+// so a new seed produces a completely new sequence of samples.
+func (w *WeightedDist) reset(seed *drbg.Seed) {
+	w.Lock()
+	defer w.Unlock()
+
+	drbgInst, err := drbg.NewHashDrbg(seed)
+	if err != nil {
+		panic("probdist: failed to initialize DRBG: " + err.Error())
+	}
+	w.seed = seed
+	w.rng = rand.New(drbgInst)
+
+	w.weights = make([]float64, w.maxValue-w.minValue+1)
+	for i := range w.weights {
+		if w.biased {
+			w.weights[i] = 1.0 / float64(i+1)
+		} else {
+			w.weights[i] = 1.0
+		}
+	}
+}
+
+// Reset regenerates the distribution using a new seed, preserving the
+// configured range and bias.
+func (w *WeightedDist) Reset(seed *drbg.Seed) {
+	w.reset(seed)
+}
+
+// Sample returns a value sampled from the distribution.
+func (w *WeightedDist) Sample() int {
+	w.Lock()
+	defer w.Unlock()
+
+	var total float64
+	for _, weight := range w.weights {
+		total += weight
+	}
+
+	target := w.rng.Float64() * total
+	var cumulative float64
+	for i, weight := range w.weights {
+		cumulative += weight
+		if target < cumulative {
+			return w.minValue + i
+		}
+	}
+	return w.maxValue
+}
+
+// Seed returns the seed currently backing the distribution.
+func (w *WeightedDist) Seed() *drbg.Seed {
+	w.Lock()
+	defer w.Unlock()
+	return w.seed
+}
@@ -0,0 +1,76 @@
+package probdist
+
+import (
+	"testing"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/drbg"
+)
+
+func TestWeightedDistDeterministic(t *testing.T) {
+	seed, err := drbg.NewSeed()
+	if err != nil {
+		t.Fatalf("drbg.NewSeed: %v", err)
+	}
+
+	a := New(seed, 0, 255, true)
+	b := New(seed, 0, 255, true)
+
+	for i := 0; i < 32; i++ {
+		sa, sb := a.Sample(), b.Sample()
+		if sa != sb {
+			t.Fatalf("sample %d diverged: %d != %d", i, sa, sb)
+		}
+		if sa < 0 || sa > 255 {
+			t.Fatalf("sample %d out of range: %d", i, sa)
+		}
+	}
+}
+
+func TestWeightedDistHonorsMinValue(t *testing.T) {
+	seed, err := drbg.NewSeed()
+	if err != nil {
+		t.Fatalf("drbg.NewSeed: %v", err)
+	}
+
+	w := New(seed, 100, 200, false)
+	for i := 0; i < 256; i++ {
+		s := w.Sample()
+		if s < 100 || s > 200 {
+			t.Fatalf("sample %d out of range [100, 200]: %d", i, s)
+		}
+	}
+}
+
+func TestWeightedDistResetReseeds(t *testing.T) {
+	seed1, err := drbg.NewSeed()
+	if err != nil {
+		t.Fatalf("drbg.NewSeed: %v", err)
+	}
+	seed2, err := drbg.NewSeed()
+	if err != nil {
+		t.Fatalf("drbg.NewSeed: %v", err)
+	}
+
+	w := New(seed1, 0, 255, false)
+	before := make([]int, 8)
+	for i := range before {
+		before[i] = w.Sample()
+	}
+
+	w.Reset(seed2)
+	after := make([]int, 8)
+	for i := range after {
+		after[i] = w.Sample()
+	}
+
+	same := true
+	for i := range before {
+		if before[i] != after[i] {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Fatal("Reset with a different seed produced the same sample sequence")
+	}
+}
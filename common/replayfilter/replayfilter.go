@@ -0,0 +1,71 @@
+// Package replayfilter implements a bloom-filter backed replay detector,
+// used by handshake code to reject a client/server hello that reuses a
+// previously seen ephemeral public value.
+package replayfilter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/riobard/go-bloom"
+)
+
+const (
+	// defaultFilterEntries is sized generously for the handshake rate a
+	// single bridge is expected to see between filter resets.
+	defaultFilterEntries = 1000000
+	defaultFilterFP      = 1e-6
+)
+
+// ReplayFilter is a time-bounded bloom filter of previously seen byte
+// strings. It is safe for concurrent use.
+type ReplayFilter struct {
+	sync.Mutex
+
+	filter    bloom.Filter
+	createdAt time.Time
+	ttl       time.Duration
+}
+
+// New creates a ReplayFilter that is considered valid for ttl before the
+// caller should discard it and create a fresh one.
+func New(ttl time.Duration) *ReplayFilter {
+	return &ReplayFilter{
+		filter:    bloom.New(defaultFilterEntries, defaultFilterFP, hashVal),
+		createdAt: time.Now(),
+		ttl:       ttl,
+	}
+}
+
+// hashVal implements the two-hash scheme go-bloom's New expects, deriving
+// both values from a single sha256 sum of val so the filter only needs one
+// underlying hash function.
+func hashVal(val []byte) (uint64, uint64) {
+	sum := sha256.Sum256(val)
+	return binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16])
+}
+
+// TestAndSet queries the filter for val, inserting it if not already
+// present, and returns true if val had already been seen (i.e. this is a
+// replay).
+func (f *ReplayFilter) TestAndSet(val []byte) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filter.Test(val) {
+		return true
+	}
+	f.filter.Add(val)
+	return false
+}
+
+// Expired returns true once the filter has outlived its configured ttl and
+// should be rotated out for a fresh one.
+func (f *ReplayFilter) Expired() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	return time.Since(f.createdAt) > f.ttl
+}
@@ -0,0 +1,32 @@
+package replayfilter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTestAndSetDetectsReplay(t *testing.T) {
+	f := New(time.Hour)
+
+	val := []byte("ephemeral-public-value")
+	if f.TestAndSet(val) {
+		t.Fatal("first sight of val reported as a replay")
+	}
+	if !f.TestAndSet(val) {
+		t.Fatal("second sight of val not reported as a replay")
+	}
+
+	other := []byte("different-value")
+	if f.TestAndSet(other) {
+		t.Fatal("first sight of a distinct value reported as a replay")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	f := New(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if !f.Expired() {
+		t.Fatal("filter with a 1ms ttl should be expired after 5ms")
+	}
+}
@@ -31,16 +31,23 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/yawning/obfs4/framing"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/drbg"
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/state"
 )
 
 const (
 	packetOverhead          = 2 + 1
 	maxPacketPayloadLength  = framing.MaximumFramePayloadLength - packetOverhead
 	maxPacketPaddingLength  = maxPacketPayloadLength
-	seedPacketPayloadLength = DrbgSeedLength
+	seedPacketPayloadLength = drbg.SeedLength
 
 	consumeReadSize = framing.MaximumSegmentLength * 16
 )
@@ -48,8 +55,143 @@ const (
 const (
 	packetTypePayload = iota
 	packetTypePrngSeed
+
+	// packetTypeHeartbeat carries a timestamp so a peer can be pinged for
+	// liveness and RTT, without the censor-visible signature a TCP-layer
+	// keepalive would have.
+	packetTypeHeartbeat
+	// packetTypePadding asks the peer to emit count padding frames spread
+	// over roughly duration, for timing-defense cover traffic.
+	packetTypePadding
+	// packetTypeClose signals a clean protocol-level shutdown with a reason
+	// code, so a half-closed TCP connection doesn't look like an abrupt RST.
+	packetTypeClose
 )
 
+// heartbeatPayloadLength is the size of a packetTypeHeartbeat payload: an
+// 8 byte big-endian Unix nanosecond timestamp.
+const heartbeatPayloadLength = 8
+
+// paddingRequestPayloadLength is the size of a packetTypePadding payload:
+// a uint16 frame count followed by a uint32 duration in milliseconds.
+const paddingRequestPayloadLength = 2 + 4
+
+// ControlHandler is implemented by callers that want to react to control
+// packet types consumeFramedPackets cannot fully handle itself
+// (packetTypeHeartbeat, packetTypePadding, packetTypeClose). A handler that
+// simply returns without acting on a given pktType preserves today's
+// "ignore unrecognised packet types" forward-compatibility behavior.
+type ControlHandler interface {
+	HandleControl(pktType uint8, payload []byte)
+}
+
+// controlHandlers tracks, per live connection, the ControlHandler that
+// should see its received heartbeat/padding/close packets. Registration is
+// keyed on the connection rather than a dedicated Obfs4Conn field so this
+// chunk doesn't need to touch the Obfs4Conn struct definition.
+var controlHandlers sync.Map // conn *Obfs4Conn -> ControlHandler
+
+// RegisterControlHandler installs handler as the recipient of c's received
+// control packets (packetTypeHeartbeat, packetTypePadding, packetTypeClose).
+func RegisterControlHandler(c *Obfs4Conn, handler ControlHandler) {
+	controlHandlers.Store(c, handler)
+}
+
+// UnregisterControlHandler removes c's registered ControlHandler, if any.
+// Close (outside this chunk) is expected to call this once c is torn down,
+// so a long-running bridge doesn't accumulate a sync.Map entry per
+// connection it has ever handled.
+func UnregisterControlHandler(c *Obfs4Conn) {
+	controlHandlers.Delete(c)
+}
+
+// DefaultControlHandler is the ControlHandler StartHeartbeat installs when
+// the caller hasn't registered one of its own: for each received heartbeat
+// it records the local receive time minus the timestamp the heartbeat
+// carries (it sends no reply), emits the padding frames its peer asks for,
+// and records the reason code of a clean peer-initiated close.
+type DefaultControlHandler struct {
+	conn *Obfs4Conn
+
+	mu          sync.Mutex
+	lastRTT     time.Duration
+	closeReason *uint8
+}
+
+// NewDefaultControlHandler creates a DefaultControlHandler bound to conn.
+func NewDefaultControlHandler(conn *Obfs4Conn) *DefaultControlHandler {
+	return &DefaultControlHandler{conn: conn}
+}
+
+// HandleControl implements ControlHandler.
+func (h *DefaultControlHandler) HandleControl(pktType uint8, payload []byte) {
+	switch pktType {
+	case packetTypeHeartbeat:
+		if len(payload) != heartbeatPayloadLength {
+			return
+		}
+		sentAt := int64(binary.BigEndian.Uint64(payload))
+		h.mu.Lock()
+		h.lastRTT = time.Duration(time.Now().UnixNano() - sentAt)
+		h.mu.Unlock()
+	case packetTypePadding:
+		if len(payload) != paddingRequestPayloadLength {
+			return
+		}
+		count := binary.BigEndian.Uint16(payload[0:2])
+		duration := time.Duration(binary.BigEndian.Uint32(payload[2:6])) * time.Millisecond
+		go h.conn.emitPadding(count, duration)
+	case packetTypeClose:
+		if len(payload) != 1 {
+			return
+		}
+		reason := payload[0]
+		h.mu.Lock()
+		h.closeReason = &reason
+		h.mu.Unlock()
+	}
+}
+
+// LastRTT returns the delay recorded for the most recently received
+// heartbeat: local receive time minus the sender's timestamp. This is a
+// one-way delay that includes any clock offset between the peers; no round
+// trip is measured, since heartbeats are not echoed.
+func (h *DefaultControlHandler) LastRTT() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastRTT
+}
+
+// CloseReason returns the reason code from a received packetTypeClose, and
+// whether one has been received at all.
+func (h *DefaultControlHandler) CloseReason() (reason uint8, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closeReason == nil {
+		return 0, false
+	}
+	return *h.closeReason, true
+}
+
+// emitPadding sends count padding-only frames spread evenly over duration,
+// in response to a peer's packetTypePadding request.
+func (c *Obfs4Conn) emitPadding(count uint16, duration time.Duration) {
+	if count == 0 {
+		return
+	}
+
+	interval := duration / time.Duration(count)
+	for i := uint16(0); i < count; i++ {
+		padLen := uint16(c.lenProbDist.Sample())
+		if err := c.producePacket(c.conn, packetTypePadding, nil, padLen); err != nil {
+			return
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
 // InvalidPacketLengthError is the error returned when decodePacket detects a
 // invalid packet length/
 type InvalidPacketLengthError int
@@ -68,10 +210,80 @@ func (e InvalidPayloadLengthError) Error() string {
 
 var zeroPadBytes [maxPacketPaddingLength]byte
 
+// sessionSpans holds the top-level per-connection span context that
+// producePacket/consumeFramedPackets spans are parented to via ChildOf, so
+// a session's packets show up as one connected trace instead of unrelated
+// roots. Inbound accept / outbound dial (main.go, outside this chunk) is
+// expected to open the root span and register it here; if none is
+// registered yet the first packet on the connection lazily opens one.
+var sessionSpans sync.Map // conn *Obfs4Conn -> opentracing.SpanContext
+
+// lazyRootSpans holds the root span sessionSpanContext opens for a
+// connection that was never given one via RegisterSessionSpan, so
+// UnregisterSession can Finish it instead of leaking an open span.
+var lazyRootSpans sync.Map // conn *Obfs4Conn -> opentracing.Span
+
+// RegisterSessionSpan associates span as the top-level trace for c, so
+// every subsequent producePacket/consumeFramedPackets span on c is a child
+// of it.
+func RegisterSessionSpan(c *Obfs4Conn, span opentracing.Span) {
+	sessionSpans.Store(c, span.Context())
+}
+
+// UnregisterSession releases the tracing state sessionSpanContext and
+// RegisterSessionSpan accumulated for c, finishing the lazily-created root
+// span if sessionSpanContext ever opened one. Close (outside this chunk) is
+// expected to call this once c is torn down, so a long-running bridge
+// doesn't leak a sync.Map entry, and an unfinished root span, per connection
+// it has ever handled.
+func UnregisterSession(c *Obfs4Conn) {
+	if v, ok := lazyRootSpans.Load(c); ok {
+		v.(opentracing.Span).Finish()
+		lazyRootSpans.Delete(c)
+	}
+	sessionSpans.Delete(c)
+}
+
+func sessionSpanContext(c *Obfs4Conn) opentracing.SpanContext {
+	if v, ok := sessionSpans.Load(c); ok {
+		return v.(opentracing.SpanContext)
+	}
+
+	root := opentracing.StartSpan("obfs4.session")
+	sessionSpans.Store(c, root.Context())
+	lazyRootSpans.Store(c, root)
+	return root.Context()
+}
+
+// RestoreCachedSeed loads any length-distribution seed previously cached
+// for bridgeName under stateDir and reseeds c.lenProbDist with it, then
+// registers c so the next packetTypePrngSeed it receives is cached in turn.
+// Client-side connection setup calls this once stateDir/bridgeName are
+// known, so length distribution continuity survives a reconnect.
+func (c *Obfs4Conn) RestoreCachedSeed(stateDir, bridgeName string) error {
+	state.RegisterSeedCache(c, stateDir, bridgeName)
+
+	seed, err := state.LoadCachedSeed(stateDir, bridgeName)
+	if err != nil {
+		return err
+	}
+	if seed != nil {
+		c.lenProbDist.Reset(seed)
+	}
+	return nil
+}
+
 func (c *Obfs4Conn) producePacket(w io.Writer, pktType uint8, data []byte, padLen uint16) (err error) {
+	span := opentracing.StartSpan("obfs4.produce_packet", opentracing.ChildOf(sessionSpanContext(c)))
+	span.SetTag("packet_type", pktType)
+	span.SetTag("payload_len", len(data))
+	span.SetTag("padding_len", padLen)
+	defer span.Finish()
+
 	var pkt [framing.MaximumFramePayloadLength]byte
 
 	if !c.CanReadWrite() {
+		ext.Error.Set(span, true)
 		return syscall.EINVAL
 	}
 
@@ -82,6 +294,8 @@ func (c *Obfs4Conn) producePacket(w io.Writer, pktType uint8, data []byte, padLe
 
 	defer func() {
 		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogKV("error", err.Error())
 			c.setBroken()
 		}
 	}()
@@ -108,6 +322,7 @@ func (c *Obfs4Conn) producePacket(w io.Writer, pktType uint8, data []byte, padLe
 		// All encoder errors are fatal.
 		return
 	}
+	span.SetTag("frame_len", frameLen)
 	var wrLen int
 	wrLen, err = w.Write(frame[:frameLen])
 	if err != nil {
@@ -120,8 +335,81 @@ func (c *Obfs4Conn) producePacket(w io.Writer, pktType uint8, data []byte, padLe
 	return
 }
 
+// SendControl frames and transmits a control packet of the given type,
+// carrying payload inside the existing AEAD frame. It is a thin wrapper
+// around producePacket for callers that aren't shuttling application data.
+func (c *Obfs4Conn) SendControl(pktType uint8, payload []byte) error {
+	if len(payload) > maxPacketPayloadLength {
+		return InvalidPayloadLengthError(len(payload))
+	}
+	return c.producePacket(c.conn, pktType, payload, 0)
+}
+
+// SendHeartbeat sends a packetTypeHeartbeat carrying the current time. A
+// peer's DefaultControlHandler does not echo it back; it records the time
+// elapsed since that timestamp against its own clock.
+func (c *Obfs4Conn) SendHeartbeat() error {
+	var payload [heartbeatPayloadLength]byte
+	binary.BigEndian.PutUint64(payload[:], uint64(time.Now().UnixNano()))
+	return c.SendControl(packetTypeHeartbeat, payload[:])
+}
+
+// RequestPadding asks the peer to emit count padding frames spread over
+// roughly duration, as a timing defense.
+func (c *Obfs4Conn) RequestPadding(count uint16, duration time.Duration) error {
+	var payload [paddingRequestPayloadLength]byte
+	binary.BigEndian.PutUint16(payload[0:2], count)
+	binary.BigEndian.PutUint32(payload[2:6], uint32(duration/time.Millisecond))
+	return c.SendControl(packetTypePadding, payload[:])
+}
+
+// SendClose sends a packetTypeClose with the given reason code, so the peer
+// can distinguish a clean protocol-level shutdown from a network failure.
+func (c *Obfs4Conn) SendClose(reason uint8) error {
+	return c.SendControl(packetTypeClose, []byte{reason})
+}
+
+// StartHeartbeat launches a background goroutine that sends heartbeats at a
+// jittered interval until stop is closed. The jitter is drawn from
+// lenProbDist so the keepalive cadence itself is shaped rather than
+// perfectly periodic. If c doesn't already have a ControlHandler registered
+// via RegisterControlHandler, StartHeartbeat installs a DefaultControlHandler
+// and returns it so the caller can inspect the heartbeat delay and close
+// reason.
+func (c *Obfs4Conn) StartHeartbeat(stop <-chan struct{}, baseInterval time.Duration) *DefaultControlHandler {
+	var handler *DefaultControlHandler
+	if _, ok := controlHandlers.Load(c); !ok {
+		handler = NewDefaultControlHandler(c)
+		RegisterControlHandler(c, handler)
+	}
+
+	go c.heartbeatLoop(stop, baseInterval)
+	return handler
+}
+
+func (c *Obfs4Conn) heartbeatLoop(stop <-chan struct{}, baseInterval time.Duration) {
+	for {
+		jitter := time.Duration(c.lenProbDist.Sample()) * time.Millisecond
+		timer := time.NewTimer(baseInterval + jitter)
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			if err := c.SendHeartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
+
 func (c *Obfs4Conn) consumeFramedPackets(w io.Writer) (n int, err error) {
+	span := opentracing.StartSpan("obfs4.consume_frame", opentracing.ChildOf(sessionSpanContext(c)))
+	defer span.Finish()
+
 	if !c.CanReadWrite() {
+		ext.Error.Set(span, true)
 		return n, syscall.EINVAL
 	}
 
@@ -130,15 +418,28 @@ func (c *Obfs4Conn) consumeFramedPackets(w io.Writer) (n int, err error) {
 	c.receiveBuffer.Write(buf[:rdLen])
 	var decoded [framing.MaximumFramePayloadLength]byte
 	for c.receiveBuffer.Len() > 0 {
+		// Each decoded frame gets its own child span, since one Read can
+		// decode several frames (consumeReadSize is MaximumSegmentLength*16)
+		// and reusing a single span would have later frames overwrite
+		// earlier frames' tags.
+		frameSpan := opentracing.StartSpan("obfs4.consume_frame.packet", opentracing.ChildOf(span.Context()))
+
 		// Decrypt an AEAD frame.
 		decLen := 0
 		decLen, err = c.decoder.Decode(decoded[:], &c.receiveBuffer)
 		if err == framing.ErrAgain {
+			frameSpan.Finish()
 			break
 		} else if err != nil {
+			ext.Error.Set(frameSpan, true)
+			frameSpan.LogKV("error", err.Error())
+			frameSpan.Finish()
 			break
 		} else if decLen < packetOverhead {
 			err = InvalidPacketLengthError(decLen)
+			ext.Error.Set(frameSpan, true)
+			frameSpan.LogKV("error", err.Error())
+			frameSpan.Finish()
 			break
 		}
 
@@ -148,9 +449,17 @@ func (c *Obfs4Conn) consumeFramedPackets(w io.Writer) (n int, err error) {
 		payloadLen := binary.BigEndian.Uint16(pkt[1:])
 		if int(payloadLen) > len(pkt)-packetOverhead {
 			err = InvalidPayloadLengthError(int(payloadLen))
+			ext.Error.Set(frameSpan, true)
+			frameSpan.LogKV("error", err.Error())
+			frameSpan.Finish()
 			break
 		}
 		payload := pkt[3 : 3+payloadLen]
+		padLen := decLen - packetOverhead - int(payloadLen)
+		frameSpan.SetTag("packet_type", pktType)
+		frameSpan.SetTag("payload_len", payloadLen)
+		frameSpan.SetTag("padding_len", padLen)
+		frameSpan.SetTag("frame_len", decLen)
 
 		switch pktType {
 		case packetTypePayload:
@@ -175,16 +484,33 @@ func (c *Obfs4Conn) consumeFramedPackets(w io.Writer) (n int, err error) {
 		case packetTypePrngSeed:
 			// Only regenerate the distribution if we are the client.
 			if len(payload) == seedPacketPayloadLength && !c.isServer {
-				var seed *DrbgSeed
-				seed, err = DrbgSeedFromBytes(payload)
+				var seed *drbg.Seed
+				seed, err = drbg.SeedFromBytes(payload)
 				if err != nil {
 					break
 				}
-				c.lenProbDist.reset(seed)
+				c.lenProbDist.Reset(seed)
+
+				// Best-effort: a connection that never called
+				// RestoreCachedSeed has nothing registered and this is a
+				// no-op.
+				if perr := state.PersistSeed(c, seed); perr != nil {
+					frameSpan.LogKV("seed_cache_error", perr.Error())
+				}
+			}
+		case packetTypeHeartbeat, packetTypePadding, packetTypeClose:
+			if v, ok := controlHandlers.Load(c); ok {
+				v.(ControlHandler).HandleControl(pktType, payload)
 			}
 		default:
 			// Ignore unrecognised packet types.
 		}
+
+		if err != nil {
+			ext.Error.Set(frameSpan, true)
+			frameSpan.LogKV("error", err.Error())
+		}
+		frameSpan.Finish()
 	}
 
 	// Read errors and non-framing.ErrAgain errors are all fatal.
@@ -0,0 +1,108 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/drbg"
+)
+
+// seedCacheFileName is the client-side cache of the most recently received
+// packetTypePrngSeed per bridge, so length-distribution continuity survives
+// a reconnect.
+const seedCacheFileName = "client_seedcache.json"
+
+// seedCacheTargets tracks, per live connection, where that connection's
+// received PRNG seed should be persisted. Obfs4Conn lives in a different
+// package chunk than this one, so a connection registers itself here
+// instead of this package holding a reference to the Obfs4Conn type.
+var seedCacheTargets sync.Map // conn interface{} -> seedCacheTarget
+
+type seedCacheTarget struct {
+	stateDir   string
+	bridgeName string
+}
+
+// RegisterSeedCache records that seeds received on conn should be persisted
+// to stateDir under bridgeName. conn is typically an *obfs4.Obfs4Conn,
+// passed as interface{} to avoid an import cycle.
+func RegisterSeedCache(conn interface{}, stateDir, bridgeName string) {
+	seedCacheTargets.Store(conn, seedCacheTarget{stateDir: stateDir, bridgeName: bridgeName})
+}
+
+// UnregisterSeedCache removes conn's seed-cache registration made via
+// RegisterSeedCache, if any. Close (outside this package) is expected to
+// call this once conn is torn down, so a long-running bridge doesn't
+// accumulate a sync.Map entry per connection it has ever handled.
+func UnregisterSeedCache(conn interface{}) {
+	seedCacheTargets.Delete(conn)
+}
+
+// PersistSeed saves seed for whichever bridge conn was registered under via
+// RegisterSeedCache. It is a no-op if conn was never registered.
+func PersistSeed(conn interface{}, seed *drbg.Seed) error {
+	v, ok := seedCacheTargets.Load(conn)
+	if !ok {
+		return nil
+	}
+	target := v.(seedCacheTarget)
+	return SaveCachedSeed(target.stateDir, target.bridgeName, seed)
+}
+
+// LoadCachedSeed returns the last persisted drbg.Seed for bridgeName, if
+// any.
+func LoadCachedSeed(stateDir, bridgeName string) (*drbg.Seed, error) {
+	cache, err := readSeedCache(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	hexSeed, ok := cache[bridgeName]
+	if !ok {
+		return nil, nil
+	}
+	return drbg.SeedFromHex(hexSeed)
+}
+
+// SaveCachedSeed persists seed for bridgeName, overwriting any previously
+// cached value.
+func SaveCachedSeed(stateDir, bridgeName string, seed *drbg.Seed) error {
+	cache, err := readSeedCache(stateDir)
+	if err != nil {
+		return err
+	}
+
+	cache[bridgeName] = seed.Hex()
+
+	raw, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("state: failed to marshal seed cache: %w", err)
+	}
+
+	path := filepath.Join(stateDir, seedCacheFileName)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return fmt.Errorf("state: failed to write %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func readSeedCache(stateDir string) (map[string]string, error) {
+	path := filepath.Join(stateDir, seedCacheFileName)
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("state: failed to read %s: %w", path, err)
+	}
+
+	cache := make(map[string]string)
+	if err := json.Unmarshal(raw, &cache); err != nil {
+		return nil, fmt.Errorf("state: failed to parse %s: %w", path, err)
+	}
+	return cache, nil
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/drbg"
+)
+
+func TestSeedCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	seed, err := drbg.NewSeed()
+	if err != nil {
+		t.Fatalf("drbg.NewSeed: %v", err)
+	}
+
+	if err := SaveCachedSeed(dir, "bridge1", seed); err != nil {
+		t.Fatalf("SaveCachedSeed: %v", err)
+	}
+
+	loaded, err := LoadCachedSeed(dir, "bridge1")
+	if err != nil {
+		t.Fatalf("LoadCachedSeed: %v", err)
+	}
+	if loaded == nil || loaded.Hex() != seed.Hex() {
+		t.Fatalf("loaded seed %v does not match saved seed %v", loaded, seed)
+	}
+
+	if _, err := LoadCachedSeed(dir, "no-such-bridge"); err != nil {
+		t.Fatalf("LoadCachedSeed for unknown bridge should not error: %v", err)
+	}
+}
+
+func TestPersistSeedUnregisteredConnIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	seed, err := drbg.NewSeed()
+	if err != nil {
+		t.Fatalf("drbg.NewSeed: %v", err)
+	}
+
+	conn := new(int)
+	if err := PersistSeed(conn, seed); err != nil {
+		t.Fatalf("PersistSeed on unregistered conn should be a no-op, got: %v", err)
+	}
+
+	if loaded, _ := LoadCachedSeed(dir, "bridge1"); loaded != nil {
+		t.Fatalf("expected no cached seed, got %v", loaded)
+	}
+}
@@ -0,0 +1,155 @@
+// Package state manages the dispatcher's per-transport PT state directory:
+// generating node-id/keypair/DRBG seed material on first run, persisting it
+// as JSON under TOR_PT_STATE_LOCATION, and reloading it on subsequent runs
+// so a restarted bridge keeps the same identity.
+package state
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/csrand"
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/drbg"
+	"github.com/OperatorFoundation/shapeshifter-dispatcher/common/elligator2"
+)
+
+// JSONFileVersion is stored in every state file so a future format change
+// can detect and migrate older files.
+const JSONFileVersion = 1
+
+// State is the persisted identity and length-distribution seed for a single
+// server-side transport instance.
+type State struct {
+	JSONVersion   int    `json:"version"`
+	NodeID        string `json:"node-id"`
+	PrivateKey    string `json:"private-key"`
+	PublicKey     string `json:"public-key"`
+	DrbgSeed      string `json:"drbg-seed"`
+}
+
+// fileName returns the state file name used for the named transport, e.g.
+// "obfs4_state.json".
+func fileName(transport string) string {
+	return transport + "_state.json"
+}
+
+// bridgelineFileName returns the bridgeline file name used for the named
+// transport, e.g. "obfs4_bridgeline.txt".
+func bridgelineFileName(transport string) string {
+	return transport + "_bridgeline.txt"
+}
+
+// LoadOrGenerate loads the persisted State for transport from stateDir,
+// generating and persisting new state on first run. enableLogging gates a
+// single audit line emitted when new state is generated.
+func LoadOrGenerate(stateDir, transport string, enableLogging bool) (*State, error) {
+	path := filepath.Join(stateDir, fileName(transport))
+
+	if raw, err := ioutil.ReadFile(path); err == nil {
+		var st State
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return nil, fmt.Errorf("state: failed to parse %s: %w", path, err)
+		}
+		return &st, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("state: failed to read %s: %w", path, err)
+	}
+
+	st, err := generate(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeAtomic(path, st); err != nil {
+		return nil, err
+	}
+
+	if err := writeBridgeline(stateDir, transport, st); err != nil {
+		return nil, err
+	}
+
+	if enableLogging {
+		log.Printf("state: generated new %s state, node-id %s", transport, st.NodeID)
+	}
+
+	return st, nil
+}
+
+// generate creates fresh node-id, curve25519 keypair, and DRBG seed
+// material for transport, using the dispatcher's shared CSPRNG. The
+// keypair comes from elligator2.GenerateKeypair, which retries until the
+// public point has a valid Elligator2 uniform representative, since only
+// about half of random curve25519 points qualify and the obfs4 handshake
+// requires one that does.
+func generate(transport string) (*State, error) {
+	priv, pub, _, err := elligator2.GenerateKeypair()
+	if err != nil {
+		return nil, fmt.Errorf("state: failed to generate %s keypair: %w", transport, err)
+	}
+
+	seed, err := drbg.NewSeed()
+	if err != nil {
+		return nil, fmt.Errorf("state: failed to generate %s drbg seed: %w", transport, err)
+	}
+
+	return &State{
+		JSONVersion: JSONFileVersion,
+		NodeID:      fmt.Sprintf("%x", csrand.Bytes(20)),
+		PrivateKey:  fmt.Sprintf("%x", priv[:]),
+		PublicKey:   fmt.Sprintf("%x", pub[:]),
+		DrbgSeed:    seed.Hex(),
+	}, nil
+}
+
+// writeAtomic writes st to path as 0600-permissioned JSON, via a temp file
+// and rename so a crash mid-write can never leave a truncated state file.
+func writeAtomic(path string, st *State) error {
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("state: failed to marshal state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return fmt.Errorf("state: failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("state: failed to rename %s to %s: %w", tmp, path, err)
+	}
+	return nil
+}
+
+// writeBridgeline emits the SMETHOD arguments an operator needs to hand out
+// a working bridge line for transport.
+func writeBridgeline(stateDir, transport string, st *State) error {
+	cert, err := certFromState(st)
+	if err != nil {
+		return fmt.Errorf("state: failed to derive %s cert: %w", transport, err)
+	}
+
+	path := filepath.Join(stateDir, bridgelineFileName(transport))
+	line := fmt.Sprintf("Bridge %s <IP>:<PORT> %s cert=%s iat-mode=0\n", transport, st.NodeID, cert)
+	return ioutil.WriteFile(path, []byte(line), 0600)
+}
+
+// certFromState computes the base64 ntor cert (node-id || public-key),
+// matching the format obfs4 bridge lines expect for their "cert=" argument.
+func certFromState(st *State) (string, error) {
+	nodeID, err := hex.DecodeString(st.NodeID)
+	if err != nil {
+		return "", fmt.Errorf("invalid node-id: %w", err)
+	}
+	pubKey, err := hex.DecodeString(st.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public-key: %w", err)
+	}
+
+	cert := append(append([]byte{}, nodeID...), pubKey...)
+	return base64.RawStdEncoding.EncodeToString(cert), nil
+}
@@ -0,0 +1,42 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestLoadOrGenerateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := LoadOrGenerate(dir, "obfs4", false)
+	if err != nil {
+		t.Fatalf("LoadOrGenerate: %v", err)
+	}
+	if first.NodeID == "" || first.PrivateKey == "" || first.PublicKey == "" || first.DrbgSeed == "" {
+		t.Fatalf("generated state has empty fields: %+v", first)
+	}
+
+	second, err := LoadOrGenerate(dir, "obfs4", false)
+	if err != nil {
+		t.Fatalf("LoadOrGenerate (reload): %v", err)
+	}
+
+	if second.NodeID != first.NodeID || second.PrivateKey != first.PrivateKey ||
+		second.PublicKey != first.PublicKey || second.DrbgSeed != first.DrbgSeed {
+		t.Fatalf("reloaded state does not match generated state: got %+v, want %+v", second, first)
+	}
+}
+
+func TestCertFromState(t *testing.T) {
+	st := &State{
+		NodeID:    "0011223344556677889900112233445566778899",
+		PublicKey: "0011223344556677889900112233445566778899001122334455667788990011",
+	}
+
+	cert, err := certFromState(st)
+	if err != nil {
+		t.Fatalf("certFromState: %v", err)
+	}
+	if cert == "" {
+		t.Fatal("certFromState returned an empty cert")
+	}
+}
@@ -0,0 +1,127 @@
+// Package trace wires OpenTracing instrumentation into the dispatcher's
+// transport pipeline. It is deliberately thin: the tracer is installed once
+// as the OpenTracing global tracer at startup, and call sites elsewhere in
+// the dispatcher (notably the obfs4 packet layer) just do
+// opentracing.StartSpan(name, opentracing.ChildOf(parent)) against whatever
+// per-session span they've registered, so related spans chain into one
+// trace instead of each packet opening an unlinked root.
+package trace
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/opentracing/opentracing-go"
+	zipkintracer "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"github.com/openzipkin/zipkin-go"
+	zipkinhttp "github.com/openzipkin/zipkin-go/reporter/http"
+	jaeger "github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+)
+
+// Tracing holds the operator-configurable tracing settings. The zero value
+// is "tracing disabled", so embedding this struct in the dispatcher's config
+// and leaving it unset is always safe.
+type Tracing struct {
+	// Enabled turns tracing on. When false, Init installs a no-op tracer and
+	// every other field is ignored.
+	Enabled bool
+
+	// Type selects the tracer implementation: "zipkin", "jaeger", or "noop".
+	Type string
+
+	// Endpoint is where spans are reported: the zipkin HTTP collector URL,
+	// or the jaeger agent host:port. Kafka reporting is not supported.
+	Endpoint string
+
+	// ServiceName identifies this dispatcher instance in the tracing backend.
+	ServiceName string
+
+	// SamplerRate is the fraction of traces to sample, in [0.0, 1.0].
+	SamplerRate float64
+
+	// SpanHost is the host:port of the zipkin tracer's local endpoint, so
+	// spans from multiple bridges are distinguishable in the backend. The
+	// jaeger tracer does not use it.
+	SpanHost string
+}
+
+// RegisterFlags registers the -tracing* command-line flags that populate t.
+// It mirrors the flag.FlagSet style used for the rest of the dispatcher's
+// command-line options.
+func (t *Tracing) RegisterFlags(fs interface {
+	BoolVar(p *bool, name string, value bool, usage string)
+	StringVar(p *string, name string, value string, usage string)
+	Float64Var(p *float64, name string, value float64, usage string)
+}) {
+	fs.BoolVar(&t.Enabled, "tracing", false, "enable OpenTracing instrumentation")
+	fs.StringVar(&t.Type, "tracing-type", "noop", "tracing backend: zipkin, jaeger, noop")
+	fs.StringVar(&t.Endpoint, "tracing-endpoint", "", "tracing endpoint (zipkin HTTP collector URL or jaeger agent host:port)")
+	fs.StringVar(&t.ServiceName, "tracing-service-name", "shapeshifter-dispatcher", "service name reported to the tracing backend")
+	fs.Float64Var(&t.SamplerRate, "tracing-sampler-rate", 1.0, "fraction of traces to sample, in [0.0, 1.0]")
+	fs.StringVar(&t.SpanHost, "tracing-span-host", "", "local host:port of the zipkin endpoint (not used by jaeger)")
+}
+
+// Init installs the tracer described by t as the OpenTracing global tracer
+// and returns a closer that must be called at shutdown to flush any
+// buffered spans. When tracing is disabled, or Type is "noop", Init installs
+// opentracing.NoopTracer{} so instrumented call sites cost nothing.
+func Init(t Tracing) (io.Closer, error) {
+	if !t.Enabled || t.Type == "noop" {
+		opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+		return io.NopCloser(nil), nil
+	}
+
+	switch t.Type {
+	case "zipkin":
+		return initZipkin(t)
+	case "jaeger":
+		return initJaeger(t)
+	default:
+		return nil, fmt.Errorf("trace: unknown tracing type %q", t.Type)
+	}
+}
+
+func initZipkin(t Tracing) (io.Closer, error) {
+	reporter := zipkinhttp.NewReporter(t.Endpoint)
+
+	endpoint, err := zipkin.NewEndpoint(t.ServiceName, t.SpanHost)
+	if err != nil {
+		reporter.Close()
+		return nil, fmt.Errorf("trace: failed to create zipkin endpoint: %w", err)
+	}
+
+	sampler, err := zipkin.NewBoundarySampler(t.SamplerRate, 0)
+	if err != nil {
+		reporter.Close()
+		return nil, fmt.Errorf("trace: failed to create zipkin sampler: %w", err)
+	}
+
+	nativeTracer, err := zipkin.NewTracer(reporter, zipkin.WithLocalEndpoint(endpoint), zipkin.WithSampler(sampler))
+	if err != nil {
+		reporter.Close()
+		return nil, fmt.Errorf("trace: failed to create zipkin tracer: %w", err)
+	}
+
+	opentracing.SetGlobalTracer(zipkintracer.Wrap(nativeTracer))
+	return reporter, nil
+}
+
+func initJaeger(t Tracing) (io.Closer, error) {
+	cfg := jaegercfg.Configuration{
+		ServiceName: t.ServiceName,
+		Sampler: &jaegercfg.SamplerConfig{
+			Type:  jaeger.SamplerTypeProbabilistic,
+			Param: t.SamplerRate,
+		},
+		Reporter: &jaegercfg.ReporterConfig{
+			LocalAgentHostPort: t.Endpoint,
+		},
+	}
+	tracer, closer, err := cfg.NewTracer()
+	if err != nil {
+		return nil, fmt.Errorf("trace: failed to create jaeger tracer: %w", err)
+	}
+	opentracing.SetGlobalTracer(tracer)
+	return closer, nil
+}